@@ -0,0 +1,66 @@
+// Package assert checks a block's actual execution result (exit code
+// and captured stdout) against the expectation parsed from its
+// directive comment and trailing ```expected``` block.
+package assert
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Mode selects how Want is compared against the actual stdout.
+type Mode int
+
+const (
+	// Exact requires the captured stdout to equal Want exactly.
+	Exact Mode = iota
+	// Substring requires Want to appear somewhere in the captured stdout.
+	Substring
+	// Regexp treats Want as a regular expression that must match the
+	// captured stdout.
+	Regexp
+)
+
+// Expectation is what a block's directive comment asserted about its
+// own execution.
+type Expectation struct {
+	WantExit int
+	// CheckStdout reports whether the block actually asserted
+	// something about its stdout (a trailing ```expected``` block).
+	// Without it, Check only looks at WantExit: an exit-only
+	// assertion shouldn't also require stdout to be exactly empty.
+	CheckStdout bool
+	WantStdout  string
+	Mode        Mode
+}
+
+// Check compares gotExit and gotStdout against e, returning nil if
+// they satisfy the expectation, or a descriptive error if not.
+func (e Expectation) Check(gotExit int, gotStdout string) error {
+	if gotExit != e.WantExit {
+		return fmt.Errorf("exit code %d, want %d", gotExit, e.WantExit)
+	}
+	if !e.CheckStdout {
+		return nil
+	}
+	switch e.Mode {
+	case Exact:
+		if gotStdout != e.WantStdout {
+			return fmt.Errorf("stdout %q, want %q", gotStdout, e.WantStdout)
+		}
+	case Substring:
+		if !strings.Contains(gotStdout, e.WantStdout) {
+			return fmt.Errorf("stdout %q does not contain %q", gotStdout, e.WantStdout)
+		}
+	case Regexp:
+		re, err := regexp.Compile(e.WantStdout)
+		if err != nil {
+			return fmt.Errorf("bad expected regexp %q: %v", e.WantStdout, err)
+		}
+		if !re.MatchString(gotStdout) {
+			return fmt.Errorf("stdout %q does not match /%s/", gotStdout, e.WantStdout)
+		}
+	}
+	return nil
+}
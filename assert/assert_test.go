@@ -0,0 +1,31 @@
+package assert
+
+import "testing"
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		e       Expectation
+		exit    int
+		stdout  string
+		wantErr bool
+	}{
+		{"exact match", Expectation{WantExit: 0, CheckStdout: true, WantStdout: "hi\n", Mode: Exact}, 0, "hi\n", false},
+		{"exact mismatch", Expectation{WantExit: 0, CheckStdout: true, WantStdout: "hi\n", Mode: Exact}, 0, "bye\n", true},
+		{"exact empty match", Expectation{WantExit: 0, CheckStdout: true, Mode: Exact}, 0, "", false},
+		{"exact empty mismatch", Expectation{WantExit: 0, CheckStdout: true, Mode: Exact}, 0, "hi\n", true},
+		{"exit mismatch", Expectation{WantExit: 0, CheckStdout: true, WantStdout: "hi\n", Mode: Exact}, 1, "hi\n", true},
+		{"substring match", Expectation{WantExit: 0, CheckStdout: true, WantStdout: "ip", Mode: Substring}, 0, "a chip", false},
+		{"substring mismatch", Expectation{WantExit: 0, CheckStdout: true, WantStdout: "zzz", Mode: Substring}, 0, "a chip", true},
+		{"regexp match", Expectation{WantExit: 0, CheckStdout: true, WantStdout: "^ok", Mode: Regexp}, 0, "ok done", false},
+		{"regexp mismatch", Expectation{WantExit: 0, CheckStdout: true, WantStdout: "^ok", Mode: Regexp}, 0, "not ok", true},
+		{"exit-only match ignores stdout", Expectation{WantExit: 0}, 0, "anything at all", false},
+		{"exit-only mismatch", Expectation{WantExit: 0}, 1, "anything at all", true},
+	}
+	for _, test := range tests {
+		err := test.e.Check(test.exit, test.stdout)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got err %v, wantErr %v", test.name, err, test.wantErr)
+		}
+	}
+}
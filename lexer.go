@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// itemType identifies the kind of token lexItems emits.
+type itemType int
+
+const (
+	itemError itemType = iota
+	itemEOF
+	itemBlockLabel
+	itemDirective
+	itemCodeBlock
+	itemExpectedBlock
+)
+
+func (t itemType) String() string {
+	switch t {
+	case itemError:
+		return "itemError"
+	case itemEOF:
+		return "itemEOF"
+	case itemBlockLabel:
+		return "itemBlockLabel"
+	case itemDirective:
+		return "itemDirective"
+	case itemCodeBlock:
+		return "itemCodeBlock"
+	case itemExpectedBlock:
+		return "itemExpectedBlock"
+	default:
+		return "itemUnknown"
+	}
+}
+
+// item is one token produced by the lexer.
+type item struct {
+	typ itemType
+	val string
+}
+
+func (i item) String() string {
+	switch i.typ {
+	case itemEOF:
+		return "EOF"
+	case itemError:
+		return i.val
+	}
+	return fmt.Sprintf("%s:%q", i.typ, i.val)
+}
+
+const (
+	commentOpen  = "<!--"
+	commentClose = "-->"
+	fence        = "```"
+)
+
+// lexer tokenizes mdrip's markdown extension: a "<!-- @label... -->"
+// comment marks the fenced code block immediately following it, and
+// may carry a directive (e.g. "timeout=5s exit=1") alongside its
+// @labels. A fenced ```expected``` block immediately after the code
+// block documents its expected stdout.
+//
+// The whole input is tokenized up front, rather than streamed through
+// a goroutine the way text/template's lexer is, because mdrip's
+// inputs are whole markdown files, not arbitrarily large template
+// sources.
+type lexer struct {
+	items []item
+	pos   int
+}
+
+// newLex tokenizes input and returns a lexer ready to be drained with nextItem.
+func newLex(input string) *lexer {
+	return &lexer{items: lexItems(input)}
+}
+
+// nextItem returns the next item, advancing past it. Once the input
+// is exhausted it returns itemEOF forever.
+func (l *lexer) nextItem() item {
+	it := l.peekItem()
+	if l.pos < len(l.items) {
+		l.pos++
+	}
+	return it
+}
+
+// peekItem returns the next item without advancing past it.
+func (l *lexer) peekItem() item {
+	if l.pos >= len(l.items) {
+		return item{itemEOF, ""}
+	}
+	return l.items[l.pos]
+}
+
+// lexItems tokenizes the entire input in one pass.
+func lexItems(input string) []item {
+	var items []item
+	i := 0
+	for i < len(input) {
+		start := strings.Index(input[i:], commentOpen)
+		if start < 0 {
+			break
+		}
+		commentStart := i + start
+		end := strings.Index(input[commentStart:], commentClose)
+		if end < 0 {
+			return append(items, item{itemError, "unterminated comment"})
+		}
+		commentEnd := commentStart + end + len(commentClose)
+		inner := input[commentStart+len(commentOpen) : commentStart+end]
+		labels, directive := parseComment(inner)
+		i = commentEnd
+		if len(labels) == 0 {
+			continue
+		}
+		for _, label := range labels {
+			items = append(items, item{itemBlockLabel, label})
+		}
+		if directive != "" {
+			items = append(items, item{itemDirective, directive})
+		}
+
+		after, ok := findFence(input, i)
+		if !ok {
+			return append(items, item{itemError, "missing code fence after labeled comment"})
+		}
+		codeText, next, ok := readFenceBody(input, after)
+		if !ok {
+			return append(items, item{itemError, "unterminated code block"})
+		}
+		items = append(items, item{itemCodeBlock, codeText})
+		i = next
+
+		rest := input[i:]
+		trimmed := strings.TrimLeft(rest, " \t\n")
+		if strings.HasPrefix(trimmed, fence+"expected") {
+			skip := len(rest) - len(trimmed) + len(fence+"expected")
+			expected, next, ok := readFenceBody(input, i+skip)
+			if !ok {
+				return append(items, item{itemError, "unterminated expected block"})
+			}
+			items = append(items, item{itemExpectedBlock, expected})
+			i = next
+		}
+	}
+	return append(items, item{itemEOF, ""})
+}
+
+// findFence locates the next "```" fence marker at or after i and
+// returns the position just past it.
+func findFence(input string, i int) (after int, ok bool) {
+	fenceStart := strings.Index(input[i:], fence)
+	if fenceStart < 0 {
+		return i, false
+	}
+	return i + fenceStart + len(fence), true
+}
+
+// readFenceBody expects i to point just past an opening fence marker
+// (and any language tag, e.g. the "expected" in "```expected"), skips
+// to the end of that line, and reads up to the matching closing
+// "```", returning the body and the position just past the close.
+func readFenceBody(input string, i int) (body string, next int, ok bool) {
+	nl := strings.IndexByte(input[i:], '\n')
+	if nl < 0 {
+		return "", i, false
+	}
+	i += nl + 1
+	closeIdx := strings.Index(input[i:], fence)
+	if closeIdx < 0 {
+		return "", i, false
+	}
+	return input[i : i+closeIdx], i + closeIdx + len(fence), true
+}
+
+// parseComment splits a "<!-- ... -->" comment's inner text into
+// block labels (fields beginning with '@', with the '@' stripped) and
+// a directive string (the remaining fields, rejoined with spaces).
+func parseComment(inner string) (labels []string, directive string) {
+	var rest []string
+	for _, f := range strings.Fields(inner) {
+		if strings.HasPrefix(f, "@") {
+			labels = append(labels, strings.TrimPrefix(f, "@"))
+		} else {
+			rest = append(rest, f)
+		}
+	}
+	return labels, strings.Join(rest, " ")
+}
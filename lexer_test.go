@@ -44,6 +44,21 @@ var lexTests = []lexTest{
 			{itemBlockLabel, "4"},
 			{itemCodeBlock, block2},
 			tEOF}},
+	{"directive", "aa <!-- @1 timeout=5s exit=1 -->\n" +
+		"```\n" + block2 + "```\n bb\n",
+		[]item{
+			{itemBlockLabel, "1"},
+			{itemDirective, "timeout=5s exit=1"},
+			{itemCodeBlock, block2},
+			tEOF}},
+	{"expected", "aa <!-- @1 -->\n" +
+		"```\n" + block1 + "```\n" +
+		"```expected\n" + "$PATH\n" + "```\n bb\n",
+		[]item{
+			{itemBlockLabel, "1"},
+			{itemCodeBlock, block1},
+			{itemExpectedBlock, "$PATH\n"},
+			tEOF}},
 }
 
 // collect gathers the emitted items into a slice.
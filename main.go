@@ -1,24 +1,50 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/jxson/mdrip/assert"
+	"github.com/jxson/mdrip/pipeline"
+	"github.com/jxson/mdrip/report"
+	"github.com/jxson/mdrip/sandbox"
 )
 
+// toPipelineBlocks adapts a ScriptBucket's internal blocks to the
+// pipeline package's exported Block type.
+func toPipelineBlocks(bucket *ScriptBucket) []pipeline.Block {
+	blocks := make([]pipeline.Block, len(bucket.script))
+	for i, b := range bucket.script {
+		blocks[i] = pipeline.Block{Labels: b.labels, CodeText: b.codeText}
+	}
+	return blocks
+}
+
 func dumpBucket(label string, bucket *ScriptBucket) {
 	fmt.Printf("#\n# Script @%s from %s \n#\n", label, bucket.fileName)
-	delimFmt := "#" + strings.Repeat("-", 70) + "#  %s %d\n"
-	for i, block := range bucket.script {
-		fmt.Printf(delimFmt, "Start", i+1)
-		fmt.Printf("echo \"Block '%s' (%d/%d in %s) of %s\"\n####\n",
-			block.labels[0], i+1, len(bucket.script), label, bucket.fileName)
-		fmt.Print(block.codeText)
-		fmt.Printf(delimFmt, "End", i+1)
-		fmt.Println()
+	err := pipeline.Run(
+		pipeline.FromSlice(toPipelineBlocks(bucket)),
+		pipeline.EmitShell(os.Stdout, pipeline.EmitOptions{
+			Label:  label,
+			Source: bucket.fileName,
+			Total:  len(bucket.script),
+		}),
+	)
+	if err != nil {
+		// EmitShell itself cannot fail; a non-nil error here would mean a
+		// future pipeline stage was added that can, so surface it.
+		log.Fatal(err)
 	}
 }
 
@@ -58,13 +84,252 @@ func emitPreambledScript(label string, scriptBuckets []*ScriptBucket) {
 	fmt.Printf("%s\n", delim)
 }
 
-func usage() {
-	fmt.Fprintf(os.Stderr, "\nUsage:  %s {label} {fileName}...\n", os.Args[0])
-	flag.PrintDefaults()
-	fmt.Fprintf(os.Stderr,
+// blockUnit is one independently dispatchable unit of work: a single
+// labeled block from a single file.
+type blockUnit struct {
+	fileName   string
+	blockIndex int
+	block      block
+	sb         *sandbox.Sandbox
+}
+
+// shardIndex hashes key with FNV-1a and reduces it mod shards, so that
+// the same key always lands on the same shard across runs and across
+// processes.
+func shardIndex(key string, shards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// collectUnits flattens scriptBuckets into individual blocks, keeping
+// only those belonging to the given shard (when shards > 1). Each
+// bucket that contributes at least one unit gets its own fresh
+// Sandbox, so buckets running concurrently under --parallel never
+// share a scratch directory; the caller is responsible for closing
+// every Sandbox in the returned slice.
+func collectUnits(scriptBuckets []*ScriptBucket, shard, shards int, opts sandbox.Options) ([]blockUnit, []*sandbox.Sandbox, error) {
+	var units []blockUnit
+	var sandboxes []*sandbox.Sandbox
+	for _, bucket := range scriptBuckets {
+		var bucketUnits []blockUnit
+		for i, b := range bucket.script {
+			if shards > 1 {
+				key := bucket.fileName + b.labels[0]
+				if shardIndex(key, shards) != shard {
+					continue
+				}
+			}
+			bucketUnits = append(bucketUnits, blockUnit{fileName: bucket.fileName, blockIndex: i, block: b})
+		}
+		if len(bucketUnits) == 0 {
+			continue
+		}
+		sb, err := sandbox.New(opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		sandboxes = append(sandboxes, sb)
+		for i := range bucketUnits {
+			bucketUnits[i].sb = sb
+		}
+		units = append(units, bucketUnits...)
+	}
+	return units, sandboxes, nil
+}
+
+// needsBlockRuntime reports whether any block in scriptBuckets carries
+// a directive that only collectUnits/runParallel know how to honor (a
+// timeout, or a stdout/exit expectation). RunInSubShell just
+// concatenates blocks into one plain "bash -e" script, so it enforces
+// neither.
+func needsBlockRuntime(scriptBuckets []*ScriptBucket) bool {
+	for _, bucket := range scriptBuckets {
+		for _, b := range bucket.script {
+			if b.hasExpectation || b.timeoutMs > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fileLocks hands out a per-fileName *sync.Mutex, so callers touching
+// the same markdown file from different goroutines (e.g. -update
+// rewriting it) can serialize around it without serializing callers
+// touching different files.
+type fileLocks struct {
+	mu    sync.Mutex
+	byKey map[string]*sync.Mutex
+}
+
+func newFileLocks() *fileLocks {
+	return &fileLocks{byKey: make(map[string]*sync.Mutex)}
+}
+
+func (f *fileLocks) forFile(fileName string) *sync.Mutex {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	l, ok := f.byKey[fileName]
+	if !ok {
+		l = &sync.Mutex{}
+		f.byKey[fileName] = l
+	}
+	return l
+}
+
+// runParallel dispatches each block in units to a worker pool of size
+// parallel, reporting each result to rep as it completes. It returns
+// the error from rep.Done, which is non-nil if any block exited
+// non-zero, other than with the code its own exit= directive asserted.
+func runParallel(units []blockUnit, parallel int, update bool, rep report.Reporter) error {
+	if parallel < 1 {
+		parallel = 1
+	}
+	start := time.Now()
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, parallel)
+		summary report.Summary
+		locks   = newFileLocks()
+	)
+	summary.Total = len(units)
+	for _, u := range units {
+		u := u
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			rec := runBlock(u, update, locks)
+			passed := rec.ExitCode == 0 || (u.block.hasExpectation && rec.ExitCode == u.block.wantExit)
+			mu.Lock()
+			if passed {
+				summary.Passed++
+			} else {
+				summary.Failed++
+			}
+			rep.Block(rec)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	summary.Duration = time.Since(start)
+	return rep.Done(summary)
+}
+
+// runBlock executes a single block's code text in its own "bash -e"
+// process, capturing stdout and stderr independently of the other
+// blocks running alongside it. If the block carries a timeout
+// directive, the process is killed when it elapses. If the block
+// carries a stdout/exit expectation, a mismatch is reported as a
+// failure (ExitCode -2) unless update is true, in which case the
+// markdown file is rewritten with the observed stdout instead, under
+// locks's per-file lock so two blocks from the same file never race
+// on the read-patch-write. u.sb determines how isolated the block's
+// process is from the host; see package sandbox.
+func runBlock(u blockUnit, update bool, locks *fileLocks) report.Record {
+	rec := report.Record{
+		File:       u.fileName,
+		Label:      u.block.labels[0],
+		BlockIndex: u.blockIndex,
+		Start:      time.Now(),
+	}
+	ctx := context.Background()
+	if u.block.timeoutMs > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(u.block.timeoutMs)*time.Millisecond)
+		defer cancel()
+	}
+	var stdout, stderr bytes.Buffer
+	cmd, err := u.sb.Command(ctx, u.block.codeText, u.block.image, u.block.env)
+	if err != nil {
+		rec.End = time.Now()
+		rec.ExitCode = -1
+		rec.Stderr = err.Error()
+		return rec
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	rec.End = time.Now()
+	rec.Stdout = stdout.String()
+	rec.Stderr = stderr.String()
+	if ctx.Err() == context.DeadlineExceeded {
+		// A killed process also satisfies the *exec.ExitError case below
+		// (ExitCode -1, same as here), but without this check first, the
+		// timeout would go unreported in Stderr.
+		rec.ExitCode = -1
+		rec.Stderr += fmt.Sprintf("\nblock %q timed out after %dms\n", rec.Label, u.block.timeoutMs)
+	} else if exitErr, ok := err.(*exec.ExitError); ok {
+		rec.ExitCode = exitErr.ExitCode()
+	} else if err != nil {
+		rec.ExitCode = -1
+	}
+	if u.block.hasExpectation {
+		want := assert.Expectation{WantExit: u.block.wantExit, CheckStdout: u.block.checkStdout, WantStdout: u.block.wantStdout, Mode: u.block.matchMode}
+		if chkErr := want.Check(rec.ExitCode, rec.Stdout); chkErr != nil {
+			if update {
+				fileLock := locks.forFile(u.fileName)
+				fileLock.Lock()
+				err := updateExpectedBlock(u.fileName, rec.Label, rec.Stdout)
+				fileLock.Unlock()
+				if err != nil {
+					rec.Stderr += fmt.Sprintf("\n-update failed: %v\n", err)
+					rec.ExitCode = -2
+				}
+			} else {
+				rec.Stderr += fmt.Sprintf("\nassertion failed: %v\n", chkErr)
+				rec.ExitCode = -2
+			}
+		}
+	}
+	return rec
+}
+
+// updateExpectedBlock rewrites the first ```expected``` fenced block
+// following label's directive comment in fileName, replacing its body
+// with got. This lets authors regenerate golden output with -update
+// rather than hand-editing it.
+func updateExpectedBlock(fileName, label, got string) error {
+	contents, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	marker := "<!-- @" + label
+	idx := strings.Index(string(contents), marker)
+	if idx < 0 {
+		return fmt.Errorf("label %q not found in %q", label, fileName)
+	}
+	const open = "```expected\n"
+	openIdx := strings.Index(string(contents)[idx:], open)
+	if openIdx < 0 {
+		return fmt.Errorf("no ```expected``` block for label %q in %q", label, fileName)
+	}
+	bodyStart := idx + openIdx + len(open)
+	closeIdx := strings.Index(string(contents)[bodyStart:], "```")
+	if closeIdx < 0 {
+		return fmt.Errorf("unterminated ```expected``` block for label %q in %q", label, fileName)
+	}
+	bodyEnd := bodyStart + closeIdx
+	updated := string(contents)[:bodyStart] + got + string(contents)[bodyEnd:]
+	return ioutil.WriteFile(fileName, []byte(updated), 0644)
+}
+
+func usage(fs *flag.FlagSet, w io.Writer) {
+	fmt.Fprintf(w, "\nUsage:  %s {labelExpr} {fileName}...\n", fs.Name())
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+	fmt.Fprintf(w,
 		`
-Reads markdown files, extracts code blocks with a given @label, and
-either runs them in a subshell or emits them to stdout.
+Reads markdown files, extracts code blocks matching a given label
+expression, and either runs them in a subshell or emits them to
+stdout.
+
+A labelExpr is a single label (foo), or labels joined by exactly one
+of ',' (union: foo OR bar), '+' (intersection: foo AND bar), or '-'
+(difference: foo but not bar).
 
 If the markdown file contains
 
@@ -85,7 +350,7 @@ If the markdown file contains
   '''
   Blah blah blah.
 
-then the command '{this} foo {fileName}' emits: 
+then the command '{this} foo {fileName}' emits:
 
   cd $HOME
   echo "Proxima Centauri"
@@ -99,45 +364,99 @@ anything to your computer that you can.
 }
 
 func main() {
-	flag.Usage = usage
-	preambled := flag.Bool("preambled", false,
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run is main's testable body: it parses args against a fresh
+// FlagSet (rather than the global flag.CommandLine, so tests can call
+// it repeatedly) and returns the process exit code instead of calling
+// os.Exit/log.Fatal directly, so every dispatch path below — not just
+// collectUnits/runParallel/runBlock in isolation — can be exercised
+// by a test.
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("mdrip", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	preambled := fs.Bool("preambled", false,
 		"Place all scripts but first script into a subshell program.")
-	subshell := flag.Bool("subshell", false,
+	subshell := fs.Bool("subshell", false,
 		"Run extracted blocks in subshell (leaves your env vars and pwd unchanged).")
-	swallow := flag.Bool("swallow", false,
+	swallow := fs.Bool("swallow", false,
 		"Swallow errors from subshell (non-zero exit only on problems in driver code).")
-	flag.Parse()
+	numParallel := fs.Int("parallel", 1,
+		"Run this many blocks concurrently, each in its own subshell.")
+	shard := fs.Int("shard", 0,
+		"Index of the shard to run, in [0, shards). Requires --shards.")
+	shards := fs.Int("shards", 1,
+		"Total number of shards. Each block is assigned a shard by FNV-hashing "+
+			"its file name and first label, so it always lands on the same shard.")
+	reportFormat := fs.String("report", "text",
+		"Result format when running with --parallel or --shards: 'text' or 'json'.")
+	update := fs.Bool("update", false,
+		"Rewrite each block's ```expected``` body with its observed stdout instead of failing on a mismatch.")
+	list := fs.Bool("list", false,
+		"Print the blocks a label expression resolves to, one per line, and exit without running anything.")
+	sandboxMode := fs.String("sandbox", "none",
+		"Isolation for --subshell execution: 'none', 'tmpdir', or 'docker'.")
+	sandboxImage := fs.String("sandbox-image", sandbox.DefaultImage,
+		"Docker image to use with --sandbox=docker, unless a block overrides it.")
+	keep := fs.Bool("keep", false,
+		"Don't remove the --sandbox=tmpdir/docker scratch directory on exit.")
+	fs.Usage = func() { usage(fs, stderr) }
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *shard < 0 || *shards < 1 || *shard >= *shards {
+		fmt.Fprintf(stderr, "--shard must be in [0, --shards).\n")
+		usage(fs, stderr)
+		return 1
+	}
+	switch sandbox.Mode(*sandboxMode) {
+	case sandbox.None, sandbox.TmpDir, sandbox.Docker:
+	default:
+		fmt.Fprintf(stderr, "--sandbox must be one of none, tmpdir, docker.\n")
+		usage(fs, stderr)
+		return 1
+	}
 	if *swallow && !*subshell {
-		fmt.Fprintf(os.Stderr, "Makes no sense to specify --swallow but not --subshell.\n")
-		usage()
-		os.Exit(1)
+		fmt.Fprintf(stderr, "Makes no sense to specify --swallow but not --subshell.\n")
+		usage(fs, stderr)
+		return 1
 	}
-	if flag.NArg() < 2 {
-		usage()
-		os.Exit(1)
+	if fs.NArg() < 2 {
+		usage(fs, stderr)
+		return 1
 	}
-	label := flag.Arg(0)
-	scriptBuckets := make([]*ScriptBucket, flag.NArg()-1)
+	label := fs.Arg(0)
+	scriptBuckets := make([]*ScriptBucket, fs.NArg()-1)
 
-	for i := 1; i < flag.NArg(); i++ {
-		fileName := flag.Arg(i)
+	for i := 1; i < fs.NArg(); i++ {
+		fileName := fs.Arg(i)
 		contents, err := ioutil.ReadFile(fileName)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Unable to read %q\n", fileName)
-			usage()
-			os.Exit(2)
+			fmt.Fprintf(stderr, "Unable to read %q\n", fileName)
+			usage(fs, stderr)
+			return 2
 		}
 		m := Parse(string(contents))
-		script, ok := m[label]
-		if !ok {
-			fmt.Fprintf(os.Stderr, "No block labelled %q in file %q.\n", label, fileName)
-			os.Exit(3)
+		script, err := Select(label, m)
+		if err != nil {
+			fmt.Fprintf(stderr, "%v in file %q.\n", err, fileName)
+			return 3
 		}
 		scriptBuckets[i-1] = &ScriptBucket{fileName, script}
 	}
 
 	if len(scriptBuckets) < 1 {
-		return
+		return 0
+	}
+
+	if *list {
+		for _, bucket := range scriptBuckets {
+			for i, b := range bucket.script {
+				fmt.Fprintf(stdout, "%s\t%d\t%s\n", bucket.fileName, i, strings.Join(b.labels, ","))
+			}
+		}
+		return 0
 	}
 
 	if !*subshell {
@@ -146,14 +465,53 @@ func main() {
 		} else {
 			emitStraightScript(label, scriptBuckets)
 		}
-		return
+		return 0
+	}
+
+	// Route through collectUnits/runParallel whenever anything in this
+	// run needs what RunInSubShell can't provide: concurrency/sharding,
+	// JSON output, sandbox isolation, rewriting ```expected``` blocks, or
+	// enforcing a block's own timeout/exit/stdout expectation. Without
+	// this, "-subshell -update file.md" on a file with a timeout= or exit=
+	// directive would silently fall through to RunInSubShell's plain
+	// concatenated script, which checks none of it.
+	if *numParallel > 1 || *shards > 1 || *reportFormat == "json" || *update ||
+		sandbox.Mode(*sandboxMode) != sandbox.None || needsBlockRuntime(scriptBuckets) {
+		var rep report.Reporter
+		if *reportFormat == "json" {
+			rep = report.NewJSONReporter(stdout)
+		} else {
+			rep = report.NewTextReporter(stdout)
+		}
+		opts := sandbox.Options{
+			Mode:  sandbox.Mode(*sandboxMode),
+			Image: *sandboxImage,
+			Keep:  *keep,
+		}
+		units, sandboxes, err := collectUnits(scriptBuckets, *shard, *shards, opts)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		// Close every sandbox as soon as runParallel returns, on the
+		// failing path below as well as the passing one.
+		runErr := runParallel(units, *numParallel, *update, rep)
+		for _, sb := range sandboxes {
+			sb.Close()
+		}
+		if runErr != nil && !*swallow {
+			fmt.Fprintln(stderr, runErr)
+			return 1
+		}
+		return 0
 	}
 
 	result := RunInSubShell(scriptBuckets)
 	if result.err != nil {
 		Complain(result, label)
 		if !*swallow {
-			log.Fatal(result.err)
+			return 1
 		}
 	}
+	return 0
 }
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jxson/mdrip/assert"
+	"github.com/jxson/mdrip/report"
+	"github.com/jxson/mdrip/sandbox"
+)
+
+func TestShardIndexDeterministic(t *testing.T) {
+	for _, key := range []string{"a.md1", "b.md2", ""} {
+		want := shardIndex(key, 7)
+		for i := 0; i < 10; i++ {
+			if got := shardIndex(key, 7); got != want {
+				t.Errorf("shardIndex(%q, 7) = %d on call %d, want %d (first call's result)", key, got, i, want)
+			}
+		}
+	}
+}
+
+func TestShardIndexInRange(t *testing.T) {
+	for _, key := range []string{"a.md1", "b.md2", "c.md3"} {
+		if got := shardIndex(key, 4); got < 0 || got >= 4 {
+			t.Errorf("shardIndex(%q, 4) = %d, want in [0, 4)", key, got)
+		}
+	}
+}
+
+func TestCollectUnitsOneSandboxPerBucket(t *testing.T) {
+	buckets := []*ScriptBucket{
+		{fileName: "a.md", script: []block{
+			{labels: []string{"1"}, codeText: "echo a1"},
+			{labels: []string{"1"}, codeText: "echo a2"},
+		}},
+		{fileName: "b.md", script: []block{
+			{labels: []string{"1"}, codeText: "echo b1"},
+		}},
+	}
+	units, sandboxes, err := collectUnits(buckets, 0, 1, sandbox.Options{Mode: sandbox.None})
+	if err != nil {
+		t.Fatalf("collectUnits: %v", err)
+	}
+	if len(units) != 3 {
+		t.Fatalf("got %d units, want 3", len(units))
+	}
+	if len(sandboxes) != 2 {
+		t.Fatalf("got %d sandboxes, want 1 per bucket (2)", len(sandboxes))
+	}
+	if units[0].sb != units[1].sb {
+		t.Error("units from the same bucket should share a Sandbox")
+	}
+	if units[0].sb == units[2].sb {
+		t.Error("units from different buckets should not share a Sandbox")
+	}
+}
+
+func TestCollectUnitsSkipsEmptyBuckets(t *testing.T) {
+	buckets := []*ScriptBucket{
+		{fileName: "a.md", script: []block{{labels: []string{"1"}, codeText: "echo a"}}},
+	}
+	// With 2 shards, whichever shard "a.md"+"1" doesn't hash to is left
+	// with no units, and should get no Sandbox at all.
+	otherShard := shardIndex("a.md1", 2)
+	emptyShard := 1 - otherShard
+	units, sandboxes, err := collectUnits(buckets, emptyShard, 2, sandbox.Options{Mode: sandbox.None})
+	if err != nil {
+		t.Fatalf("collectUnits: %v", err)
+	}
+	if len(units) != 0 || len(sandboxes) != 0 {
+		t.Errorf("got %d units, %d sandboxes, want 0, 0", len(units), len(sandboxes))
+	}
+}
+
+// TestRunParallelUpdateDoesNotRaceAcrossBlocksInSameFile guards against
+// two -update rewrites of the same file clobbering each other: two
+// failing blocks in one file, dispatched to separate workers, must
+// both end up with their own observed stdout in the rewritten file.
+func TestRunParallelUpdateDoesNotRaceAcrossBlocksInSameFile(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "doc.md")
+	contents := "<!-- @a exit=0 -->\n```\necho one\n```\n```expected\nstale\n```\n" +
+		"<!-- @b exit=0 -->\n```\necho two\n```\n```expected\nstale\n```\n"
+	if err := os.WriteFile(fileName, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bucket := &ScriptBucket{fileName: fileName, script: []block{
+		{labels: []string{"a"}, codeText: "echo one\n", hasExpectation: true, checkStdout: true, wantStdout: "stale", matchMode: assert.Exact},
+		{labels: []string{"b"}, codeText: "echo two\n", hasExpectation: true, checkStdout: true, wantStdout: "stale", matchMode: assert.Exact},
+	}}
+	units, sandboxes, err := collectUnits([]*ScriptBucket{bucket}, 0, 1, sandbox.Options{Mode: sandbox.None})
+	if err != nil {
+		t.Fatalf("collectUnits: %v", err)
+	}
+	for _, sb := range sandboxes {
+		defer sb.Close()
+	}
+	if err := runParallel(units, 2, true, report.NewTextReporter(new(strings.Builder))); err != nil {
+		t.Fatalf("runParallel: %v", err)
+	}
+	got, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), "```expected\none\n```") || !strings.Contains(string(got), "```expected\ntwo\n```") {
+		t.Errorf("one block's -update clobbered the other, got:\n%s", got)
+	}
+}
+
+func TestRunBlockTimeoutFires(t *testing.T) {
+	sb, err := sandbox.New(sandbox.Options{Mode: sandbox.None})
+	if err != nil {
+		t.Fatalf("sandbox.New: %v", err)
+	}
+	defer sb.Close()
+	u := blockUnit{
+		fileName: "doc.md",
+		block:    block{labels: []string{"a"}, codeText: "sleep 5\n", timeoutMs: 50},
+		sb:       sb,
+	}
+	start := time.Now()
+	rec := runBlock(u, false, newFileLocks())
+	if elapsed := time.Since(start); elapsed > 4*time.Second {
+		t.Errorf("runBlock took %v, want the 50ms timeout to have killed the process well before sleep 5 returned", elapsed)
+	}
+	if rec.ExitCode != -1 {
+		t.Errorf("ExitCode = %d, want -1", rec.ExitCode)
+	}
+	if !strings.Contains(rec.Stderr, "timed out") {
+		t.Errorf("Stderr = %q, want it to mention the timeout", rec.Stderr)
+	}
+}
+
+func TestRunBlockAssertionMismatchExitCode(t *testing.T) {
+	sb, err := sandbox.New(sandbox.Options{Mode: sandbox.None})
+	if err != nil {
+		t.Fatalf("sandbox.New: %v", err)
+	}
+	defer sb.Close()
+	u := blockUnit{
+		fileName: "doc.md",
+		block: block{
+			labels:         []string{"a"},
+			codeText:       "echo wrong\n",
+			hasExpectation: true,
+			checkStdout:    true,
+			wantStdout:     "right\n",
+			matchMode:      assert.Exact,
+		},
+		sb: sb,
+	}
+	rec := runBlock(u, false, newFileLocks())
+	if rec.ExitCode != -2 {
+		t.Errorf("ExitCode = %d, want -2", rec.ExitCode)
+	}
+	if !strings.Contains(rec.Stderr, "assertion failed") {
+		t.Errorf("Stderr = %q, want it to mention the assertion failure", rec.Stderr)
+	}
+}
+
+// TestRunSubshellUpdateRewritesExpectedBlock exercises run()'s own
+// flag parsing and dispatch, not just the collectUnits/runParallel
+// helpers it wires together: plain "-subshell -update" (no -parallel,
+// -shards, or -report=json) must still route through runParallel so
+// -update actually rewrites the mismatched ```expected``` block,
+// instead of silently falling through to RunInSubShell.
+func TestRunSubshellUpdateRewritesExpectedBlock(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "doc.md")
+	contents := "<!-- @a exit=0 -->\n```\necho right\n```\n```expected\nstale\n```\n"
+	if err := os.WriteFile(fileName, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr strings.Builder
+	code := run([]string{"-subshell", "-update", "a", fileName}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run: exit %d, stderr:\n%s", code, stderr.String())
+	}
+	got, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), "```expected\nright\n```") {
+		t.Errorf("-update did not rewrite the expected block, got:\n%s", got)
+	}
+}
+
+// TestRunSubshellEnforcesTimeoutWithoutParallelOrUpdate guards the
+// same dispatch gate from the other direction: a lone timeout=
+// directive (no -update, -parallel, -shards, or -report=json flag)
+// must still route through runParallel so the timeout is enforced,
+// rather than falling through to RunInSubShell's untimed, unchecked
+// "bash -e" concatenation.
+func TestRunSubshellEnforcesTimeoutWithoutParallelOrUpdate(t *testing.T) {
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "doc.md")
+	contents := "<!-- @a timeout=50ms -->\n```\nsleep 5\n```\n"
+	if err := os.WriteFile(fileName, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr strings.Builder
+	start := time.Now()
+	code := run([]string{"-subshell", "-swallow", "a", fileName}, &stdout, &stderr)
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("run took %v, want the 50ms timeout to have killed \"sleep 5\" well before it returned on its own", elapsed)
+	}
+	if code != 0 {
+		t.Errorf("run: exit %d, want 0 (swallowed)", code)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jxson/mdrip/assert"
+)
+
+// block is one fenced code block extracted from a markdown file,
+// tagged with the @labels on its preceding comment.
+type block struct {
+	labels   []string
+	codeText string
+
+	// seq is this block's position in the document, assigned by
+	// Parse in the order blocks are encountered. Every label-copy of
+	// a multi-labeled block shares the same seq.
+	seq int
+
+	// hasExpectation, timeoutMs, wantExit, checkStdout, wantStdout,
+	// and matchMode come from the block's directive comment and/or a
+	// trailing ```expected``` fence; see assert.Expectation.
+	// checkStdout is only set by a trailing ```expected``` block, not
+	// by exit= alone, so an exit-only block isn't also held to a
+	// spurious empty-stdout assertion.
+	hasExpectation bool
+	timeoutMs      int
+	wantExit       int
+	checkStdout    bool
+	wantStdout     string
+	matchMode      assert.Mode
+
+	// image and env come from a directive comment's image=/env=
+	// fields, and override the sandbox's defaults for this block.
+	image string
+	env   []string
+}
+
+// Parse extracts every labeled block from contents and returns them
+// grouped by label, in the order they appear in the document.
+func Parse(contents string) map[string][]block {
+	m := make(map[string][]block)
+	l := newLex(contents)
+	var pending []string
+	var directive string
+	seq := 0
+	for {
+		it := l.nextItem()
+		switch it.typ {
+		case itemEOF, itemError:
+			return m
+		case itemBlockLabel:
+			pending = append(pending, it.val)
+		case itemDirective:
+			directive = it.val
+		case itemCodeBlock:
+			b := block{labels: pending, codeText: it.val, seq: seq}
+			applyDirective(&b, directive)
+			if l.peekItem().typ == itemExpectedBlock {
+				b.wantStdout = l.nextItem().val
+				b.checkStdout = true
+				b.hasExpectation = true
+			}
+			seq++
+			for _, label := range pending {
+				m[label] = append(m[label], b)
+			}
+			pending = nil
+			directive = ""
+		}
+	}
+}
+
+// applyDirective parses the key=value fields of a directive comment
+// (e.g. "timeout=5s exit=1 mode=substring image=golang:1.21 env=FOO=bar")
+// into b. An exit= field sets hasExpectation, since it's the one field
+// that only makes sense alongside a check of the block's result; a
+// trailing ```expected``` block (applied by the caller) also does.
+func applyDirective(b *block, directive string) {
+	for _, field := range strings.Fields(directive) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "timeout":
+			if d, err := time.ParseDuration(value); err == nil {
+				b.timeoutMs = int(d.Milliseconds())
+			}
+		case "exit":
+			if n, err := strconv.Atoi(value); err == nil {
+				b.wantExit = n
+				b.hasExpectation = true
+			}
+		case "mode":
+			switch value {
+			case "substring":
+				b.matchMode = assert.Substring
+			case "regexp":
+				b.matchMode = assert.Regexp
+			default:
+				b.matchMode = assert.Exact
+			}
+		case "image":
+			b.image = value
+		case "env":
+			b.env = append(b.env, value)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jxson/mdrip/assert"
+)
+
+func TestParseDirective(t *testing.T) {
+	md := "aa <!-- @1 timeout=5s exit=1 mode=substring -->\n" +
+		"```\n" + "kill -9 $pid" + "```\n bb\n"
+	blocks := Parse(md)["1"]
+	if len(blocks) != 1 {
+		t.Fatalf("got %d blocks, want 1", len(blocks))
+	}
+	b := blocks[0]
+	if b.timeoutMs != 5000 {
+		t.Errorf("timeoutMs = %d, want 5000", b.timeoutMs)
+	}
+	if !b.hasExpectation || b.wantExit != 1 {
+		t.Errorf("hasExpectation = %v, wantExit = %d, want true, 1", b.hasExpectation, b.wantExit)
+	}
+	if b.checkStdout {
+		t.Error("checkStdout = true for an exit-only directive with no ```expected``` block, want false")
+	}
+	if b.matchMode != assert.Substring {
+		t.Errorf("matchMode = %v, want Substring", b.matchMode)
+	}
+}
+
+func TestParseDirectiveImageAndEnv(t *testing.T) {
+	md := "aa <!-- @1 image=python:3.11 env=FOO=bar -->\n" +
+		"```\n" + "echo hi\n" + "```\n bb\n"
+	blocks := Parse(md)["1"]
+	if len(blocks) != 1 {
+		t.Fatalf("got %d blocks, want 1", len(blocks))
+	}
+	b := blocks[0]
+	if b.image != "python:3.11" {
+		t.Errorf("image = %q, want %q", b.image, "python:3.11")
+	}
+	if len(b.env) != 1 || b.env[0] != "FOO=bar" {
+		t.Errorf("env = %v, want [%q]", b.env, "FOO=bar")
+	}
+}
+
+func TestParseExpectedBlock(t *testing.T) {
+	md := "aa <!-- @1 -->\n" +
+		"```\n" + "echo hi\n" + "```\n" +
+		"```expected\n" + "hi\n" + "```\n bb\n"
+	blocks := Parse(md)["1"]
+	if len(blocks) != 1 {
+		t.Fatalf("got %d blocks, want 1", len(blocks))
+	}
+	b := blocks[0]
+	if !b.hasExpectation || !b.checkStdout || b.wantStdout != "hi\n" {
+		t.Errorf("hasExpectation = %v, checkStdout = %v, wantStdout = %q, want true, true, %q", b.hasExpectation, b.checkStdout, b.wantStdout, "hi\n")
+	}
+}
+
+func TestParseSeqPreservesDocumentOrder(t *testing.T) {
+	md := "aa <!-- @1 -->\n```\none\n```\n" +
+		"bb <!-- @2 -->\n```\ntwo\n```\n"
+	m := Parse(md)
+	if m["1"][0].seq >= m["2"][0].seq {
+		t.Errorf("seq out of order: %d, %d", m["1"][0].seq, m["2"][0].seq)
+	}
+}
@@ -0,0 +1,215 @@
+// Package pipeline exposes mdrip's block extraction and execution as
+// a composable set of stream filters, so a Go program can embed mdrip
+// without shelling out to the mdrip binary.
+//
+// A pipeline is built by chaining Filters with Run, in the style of
+// stream.Run(stream.ReadLines(...), stream.Grep(...), stream.Sort(),
+// stream.WriteLines(...)): each stage reads Blocks from an in channel
+// and writes Blocks to an out channel, and Run wires one stage's out
+// to the next stage's in.
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// Block is one labeled, fenced code block extracted from a markdown
+// document.
+type Block struct {
+	Labels   []string
+	CodeText string
+}
+
+// Filter is one stage of a pipeline. It reads Blocks from in until in
+// is closed, writes zero or more Blocks to out, and returns any error
+// that should abort the pipeline. Run closes out for it.
+type Filter interface {
+	Run(in <-chan Block, out chan<- Block) error
+}
+
+// FilterFunc adapts a plain function to the Filter interface.
+type FilterFunc func(in <-chan Block, out chan<- Block) error
+
+// Run implements Filter.
+func (f FilterFunc) Run(in <-chan Block, out chan<- Block) error { return f(in, out) }
+
+// Run wires filters into a pipeline, each stage's out channel feeding
+// the next stage's in channel, and drains whatever the final stage
+// emits. It returns the first non-nil error returned by any stage.
+func Run(filters ...Filter) error {
+	if len(filters) == 0 {
+		return nil
+	}
+	var in chan Block
+	errc := make(chan error, len(filters))
+	for _, f := range filters {
+		stageIn := in
+		out := make(chan Block)
+		go func(f Filter, stageIn <-chan Block, out chan Block) {
+			defer close(out)
+			err := f.Run(stageIn, out)
+			// A filter that returns early (e.g. Exec aborting on the
+			// first error) may leave blocks unread on stageIn. Drain
+			// them so the upstream stage's send doesn't block forever.
+			if stageIn != nil {
+				for range stageIn {
+				}
+			}
+			errc <- err
+		}(f, stageIn, out)
+		in = out
+	}
+	for range in {
+		// Drain the last stage so every goroutine in the chain can finish.
+	}
+	var firstErr error
+	for range filters {
+		if err := <-errc; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// FromSlice returns a source Filter that ignores in and emits blocks,
+// in order, to out. Useful for feeding a pipeline from an
+// already-parsed block slice rather than raw markdown.
+func FromSlice(blocks []Block) Filter {
+	return FilterFunc(func(in <-chan Block, out chan<- Block) error {
+		for _, b := range blocks {
+			out <- b
+		}
+		return nil
+	})
+}
+
+// ReadMarkdown returns a source Filter that reads r and, for each
+// "<!-- @label... -->" comment, emits the next fenced code block after
+// it as one Block carrying every label on that comment.
+func ReadMarkdown(r io.Reader) Filter {
+	return FilterFunc(func(in <-chan Block, out chan<- Block) error {
+		contents, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		for _, b := range scanBlocks(string(contents)) {
+			out <- b
+		}
+		return nil
+	})
+}
+
+// SelectLabel returns a Filter that keeps only blocks matching expr:
+// a single label, a ','-joined union, a '+'-joined intersection, or a
+// '-'-joined difference (first term minus the rest). Mixing operators
+// (foo,bar+baz) makes the returned Filter's Run fail with an error;
+// see SplitExpr.
+func SelectLabel(expr string) Filter {
+	match, err := labelPredicate(expr)
+	return FilterFunc(func(in <-chan Block, out chan<- Block) error {
+		if err != nil {
+			return err
+		}
+		for b := range in {
+			if match(b) {
+				out <- b
+			}
+		}
+		return nil
+	})
+}
+
+// Dedup returns a Filter that drops blocks whose label set and code
+// text exactly match one already seen earlier in the stream.
+func Dedup() Filter {
+	return FilterFunc(func(in <-chan Block, out chan<- Block) error {
+		seen := make(map[string]bool)
+		for b := range in {
+			k := strings.Join(b.Labels, "\x00") + "\x00\x00" + b.CodeText
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			out <- b
+		}
+		return nil
+	})
+}
+
+// Rewrite returns a Filter that replaces every block with fn(block),
+// e.g. to patch code text or relabel blocks mid-pipeline.
+func Rewrite(fn func(Block) Block) Filter {
+	return FilterFunc(func(in <-chan Block, out chan<- Block) error {
+		for b := range in {
+			out <- fn(b)
+		}
+		return nil
+	})
+}
+
+// EmitOptions controls the banner EmitShell writes around each block.
+type EmitOptions struct {
+	// Label is the selector the caller ran, printed in each banner.
+	Label string
+	// Source names where the blocks came from, printed in each banner.
+	Source string
+	// Total is the block count to report alongside each block's
+	// position, e.g. "(2/5 in ...)". Zero omits the total.
+	Total int
+}
+
+// EmitShell returns a Filter that writes each block to w as a
+// shell-runnable, banner-delimited script, passing every block
+// through to out unchanged so EmitShell can sit in the middle of a
+// pipeline (e.g. before Exec).
+func EmitShell(w io.Writer, opts EmitOptions) Filter {
+	delimFmt := "#" + strings.Repeat("-", 70) + "#  %s %d\n"
+	return FilterFunc(func(in <-chan Block, out chan<- Block) error {
+		i := 0
+		for b := range in {
+			i++
+			fmt.Fprintf(w, delimFmt, "Start", i)
+			pos := fmt.Sprint(i)
+			if opts.Total > 0 {
+				pos = fmt.Sprintf("%d/%d", i, opts.Total)
+			}
+			fmt.Fprintf(w, "echo \"Block '%s' (%s in %s) of %s\"\n####\n",
+				b.Labels[0], pos, opts.Source, opts.Label)
+			fmt.Fprint(w, b.CodeText)
+			fmt.Fprintf(w, delimFmt, "End", i)
+			fmt.Fprintln(w)
+			out <- b
+		}
+		return nil
+	})
+}
+
+// Runner executes a Block's CodeText, e.g. by shelling it out.
+type Runner func(b Block) error
+
+// DefaultRunner shells CodeText out to "bash -e", discarding output.
+// Embedders wanting captured output or richer semantics (timeouts,
+// assertions, sandboxing) should supply their own Runner.
+func DefaultRunner(b Block) error {
+	cmd := exec.Command("bash", "-e")
+	cmd.Stdin = strings.NewReader(b.CodeText)
+	return cmd.Run()
+}
+
+// Exec returns a sink Filter that runs every block through run,
+// aborting the pipeline on the first error, and otherwise passing
+// each block through to out unchanged.
+func Exec(run Runner) Filter {
+	return FilterFunc(func(in <-chan Block, out chan<- Block) error {
+		for b := range in {
+			if err := run(b); err != nil {
+				return err
+			}
+			out <- b
+		}
+		return nil
+	})
+}
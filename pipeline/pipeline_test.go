@@ -0,0 +1,111 @@
+package pipeline
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func collect(t *testing.T, filters ...Filter) []Block {
+	var got []Block
+	sink := FilterFunc(func(in <-chan Block, out chan<- Block) error {
+		for b := range in {
+			got = append(got, b)
+		}
+		return nil
+	})
+	if err := Run(append(filters, sink)...); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	return got
+}
+
+func TestReadMarkdown(t *testing.T) {
+	// The label comment shares a line with prose, as it does throughout
+	// mdrip's lexer_test.go.
+	md := "aa <!-- @foo -->\n```\necho hi\n```\n bb\n"
+	got := collect(t, ReadMarkdown(strings.NewReader(md)))
+	if len(got) != 1 || got[0].Labels[0] != "foo" || got[0].CodeText != "echo hi\n" {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestSelectLabel(t *testing.T) {
+	blocks := []Block{
+		{Labels: []string{"foo"}, CodeText: "a"},
+		{Labels: []string{"bar"}, CodeText: "b"},
+	}
+	got := collect(t, FromSlice(blocks), SelectLabel("foo,bar"))
+	if len(got) != 2 {
+		t.Errorf("union: got %+v", got)
+	}
+	got = collect(t, FromSlice(blocks), SelectLabel("foo+bar"))
+	if len(got) != 0 {
+		t.Errorf("intersection: got %+v", got)
+	}
+}
+
+func TestSelectLabelRejectsMixedOperators(t *testing.T) {
+	blocks := []Block{
+		{Labels: []string{"foo"}, CodeText: "a"},
+		{Labels: []string{"bar"}, CodeText: "b"},
+		{Labels: []string{"baz"}, CodeText: "c"},
+	}
+	err := Run(FromSlice(blocks), SelectLabel("foo,bar+baz"), FilterFunc(func(in <-chan Block, out chan<- Block) error {
+		for range in {
+		}
+		return nil
+	}))
+	if err == nil {
+		t.Fatal("Run: want error for mixed operators, got nil")
+	}
+}
+
+func TestSplitExpr(t *testing.T) {
+	terms, op, err := SplitExpr("foo,bar+baz")
+	if err == nil {
+		t.Errorf("SplitExpr(%q) = %v, %v, nil, want an error", "foo,bar+baz", terms, string(op))
+	}
+	terms, op, err = SplitExpr("foo,bar")
+	if err != nil || op != ',' || len(terms) != 2 {
+		t.Errorf("SplitExpr(%q) = %v, %q, %v", "foo,bar", terms, string(op), err)
+	}
+	terms, op, err = SplitExpr("foo")
+	if err != nil || op != 0 || len(terms) != 1 || terms[0] != "foo" {
+		t.Errorf("SplitExpr(%q) = %v, %q, %v", "foo", terms, string(op), err)
+	}
+}
+
+func TestRunDrainsAfterEarlyError(t *testing.T) {
+	blocks := []Block{
+		{Labels: []string{"a"}, CodeText: "1"},
+		{Labels: []string{"b"}, CodeText: "2"},
+		{Labels: []string{"c"}, CodeText: "3"},
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(FromSlice(blocks), Exec(func(b Block) error {
+			return errors.New("boom")
+		}))
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Run: want error, got nil")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return: deadlocked draining an early-erroring stage")
+	}
+}
+
+func TestDedup(t *testing.T) {
+	blocks := []Block{
+		{Labels: []string{"foo"}, CodeText: "a"},
+		{Labels: []string{"foo"}, CodeText: "a"},
+	}
+	got := collect(t, FromSlice(blocks), Dedup())
+	if len(got) != 1 {
+		t.Errorf("got %+v", got)
+	}
+}
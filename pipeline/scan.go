@@ -0,0 +1,61 @@
+package pipeline
+
+import "strings"
+
+// scanBlocks extracts every fenced code block in contents that is
+// preceded, anywhere earlier in the document, by a "<!-- @label...
+// -->" comment — the comment and the fence may each share their line
+// with other prose, matching mdrip's own lexer. This is a standalone,
+// minimal reader for embedders that want Blocks without going through
+// the mdrip binary's own lexer.
+func scanBlocks(contents string) []Block {
+	var blocks []Block
+	i := 0
+	for {
+		commentStart := strings.Index(contents[i:], "<!--")
+		if commentStart < 0 {
+			break
+		}
+		commentStart += i
+		commentEnd := strings.Index(contents[commentStart:], "-->")
+		if commentEnd < 0 {
+			break
+		}
+		commentEnd += commentStart
+		inner := contents[commentStart+len("<!--") : commentEnd]
+		labels := parseLabels(inner)
+		i = commentEnd + len("-->")
+		if len(labels) == 0 {
+			continue
+		}
+
+		fenceStart := strings.Index(contents[i:], "```")
+		if fenceStart < 0 {
+			break
+		}
+		i += fenceStart + len("```")
+		nl := strings.IndexByte(contents[i:], '\n')
+		if nl < 0 {
+			break
+		}
+		i += nl + 1
+		closeIdx := strings.Index(contents[i:], "```")
+		if closeIdx < 0 {
+			break
+		}
+		blocks = append(blocks, Block{Labels: labels, CodeText: contents[i : i+closeIdx]})
+		i += closeIdx + len("```")
+	}
+	return blocks
+}
+
+// parseLabels reports the @-prefixed labels in a comment's inner text.
+func parseLabels(inner string) []string {
+	var labels []string
+	for _, field := range strings.Fields(inner) {
+		if strings.HasPrefix(field, "@") {
+			labels = append(labels, strings.TrimPrefix(field, "@"))
+		}
+	}
+	return labels
+}
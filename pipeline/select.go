@@ -0,0 +1,87 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SplitExpr parses a label expression into its operator and terms, so
+// that mdrip's own select.go and this package's SelectLabel share one
+// implementation of the expression grammar instead of drifting apart.
+//
+// An expression is a single label (op == 0, terms holds just expr), or
+// a list of labels joined by exactly one of ',' (union), '+'
+// (intersection), or '-' (difference, first term minus the rest).
+// Mixing operators (foo,bar+baz) is rejected with an error.
+func SplitExpr(expr string) (terms []string, op byte, err error) {
+	for _, c := range []byte{',', '+', '-'} {
+		if strings.IndexByte(expr, c) < 0 {
+			continue
+		}
+		if op != 0 {
+			return nil, 0, fmt.Errorf("mdrip: cannot mix selector operators in %q", expr)
+		}
+		op = c
+	}
+	if op == 0 {
+		return []string{expr}, 0, nil
+	}
+	terms = strings.Split(expr, string(op))
+	for i, t := range terms {
+		terms[i] = strings.TrimSpace(t)
+	}
+	return terms, op, nil
+}
+
+// labelPredicate compiles expr into a function reporting whether a
+// Block matches it. See SelectLabel for the expression grammar.
+func labelPredicate(expr string) (func(Block) bool, error) {
+	terms, op, err := SplitExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	if op == 0 {
+		return func(b Block) bool { return hasLabel(b, terms[0]) }, nil
+	}
+	switch op {
+	case ',':
+		return func(b Block) bool {
+			for _, t := range terms {
+				if hasLabel(b, t) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case '+':
+		return func(b Block) bool {
+			for _, t := range terms {
+				if !hasLabel(b, t) {
+					return false
+				}
+			}
+			return true
+		}, nil
+	default: // '-'
+		return func(b Block) bool {
+			if !hasLabel(b, terms[0]) {
+				return false
+			}
+			for _, t := range terms[1:] {
+				if hasLabel(b, t) {
+					return false
+				}
+			}
+			return true
+		}, nil
+	}
+}
+
+func hasLabel(b Block, label string) bool {
+	for _, l := range b.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
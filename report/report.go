@@ -0,0 +1,93 @@
+// Package report defines the output formats for a mdrip run: the
+// existing human-readable text format, and a machine-readable JSON
+// stream suitable for CI consumption.
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Record describes the execution of a single labeled block.
+type Record struct {
+	File       string    `json:"file"`
+	Label      string    `json:"label"`
+	BlockIndex int       `json:"blockIndex"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	ExitCode   int       `json:"exitCode"`
+	Stdout     string    `json:"stdout"`
+	Stderr     string    `json:"stderr"`
+}
+
+// Summary is emitted once, after every Record, describing the run as
+// a whole.
+type Summary struct {
+	Total    int           `json:"total"`
+	Passed   int           `json:"passed"`
+	Failed   int           `json:"failed"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Reporter receives one Block call per executed block, in completion
+// order (which, under -parallel, is not necessarily document order),
+// followed by exactly one Done call.
+type Reporter interface {
+	Block(r Record)
+	Done(s Summary) error
+}
+
+// TextReporter reproduces mdrip's traditional plain-text output: a
+// line per block, and a final "All done" or error message.
+type TextReporter struct {
+	w io.Writer
+}
+
+// NewTextReporter returns a Reporter that writes human-readable lines to w.
+func NewTextReporter(w io.Writer) *TextReporter {
+	return &TextReporter{w: w}
+}
+
+func (t *TextReporter) Block(r Record) {
+	status := "ok"
+	if r.ExitCode != 0 {
+		status = fmt.Sprintf("exit %d", r.ExitCode)
+	}
+	fmt.Fprintf(t.w, "--- %s (%s in %s): %s\n", r.Label, r.File, r.End.Sub(r.Start), status)
+}
+
+func (t *TextReporter) Done(s Summary) error {
+	if s.Failed > 0 {
+		fmt.Fprintf(t.w, "FAILED: %d/%d blocks failed in %s.\n", s.Failed, s.Total, s.Duration)
+		return fmt.Errorf("%d of %d blocks failed", s.Failed, s.Total)
+	}
+	fmt.Fprintf(t.w, "All done.  No errors.  %d blocks in %s.\n", s.Total, s.Duration)
+	return nil
+}
+
+// JSONReporter streams one JSON object per block to w as it
+// completes, followed by a final summary object. Each line is a
+// complete JSON value, so the stream can be consumed with a tool
+// like jq without buffering the whole run.
+type JSONReporter struct {
+	enc *json.Encoder
+}
+
+// NewJSONReporter returns a Reporter that streams newline-delimited JSON to w.
+func NewJSONReporter(w io.Writer) *JSONReporter {
+	return &JSONReporter{enc: json.NewEncoder(w)}
+}
+
+func (j *JSONReporter) Block(r Record) {
+	j.enc.Encode(r)
+}
+
+func (j *JSONReporter) Done(s Summary) error {
+	j.enc.Encode(s)
+	if s.Failed > 0 {
+		return fmt.Errorf("%d of %d blocks failed", s.Failed, s.Total)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTextReporter(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewTextReporter(&buf)
+	r.Block(Record{Label: "foo", File: "a.md", ExitCode: 0})
+	err := r.Done(Summary{Total: 1, Passed: 1})
+	if err != nil {
+		t.Errorf("Done: %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "foo") || !strings.Contains(out, "All done") {
+		t.Errorf("got %q", out)
+	}
+}
+
+func TestTextReporterFailure(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewTextReporter(&buf)
+	r.Block(Record{Label: "foo", File: "a.md", ExitCode: 1})
+	err := r.Done(Summary{Total: 1, Failed: 1})
+	if err == nil {
+		t.Fatal("Done: want error, got nil")
+	}
+	out := buf.String()
+	if !strings.Contains(out, "exit 1") || !strings.Contains(out, "FAILED") {
+		t.Errorf("got %q", out)
+	}
+}
+
+func TestJSONReporter(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewJSONReporter(&buf)
+	r.Block(Record{Label: "foo", File: "a.md", ExitCode: 0})
+	if err := r.Done(Summary{Total: 1, Passed: 1}); err != nil {
+		t.Errorf("Done: %v", err)
+	}
+	dec := json.NewDecoder(&buf)
+	var rec Record
+	if err := dec.Decode(&rec); err != nil {
+		t.Fatalf("decode Record: %v", err)
+	}
+	if rec.Label != "foo" {
+		t.Errorf("got label %q, want foo", rec.Label)
+	}
+	var summary Summary
+	if err := dec.Decode(&summary); err != nil {
+		t.Fatalf("decode Summary: %v", err)
+	}
+	if summary.Total != 1 || summary.Passed != 1 {
+		t.Errorf("got %+v", summary)
+	}
+}
+
+func TestJSONReporterFailure(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewJSONReporter(&buf)
+	err := r.Done(Summary{Total: 1, Failed: 1})
+	if err == nil {
+		t.Fatal("Done: want error, got nil")
+	}
+}
@@ -0,0 +1,163 @@
+// Package sandbox isolates a block's execution from the caller's
+// host: from no isolation at all, through a scratch working
+// directory, up to a throwaway Docker container.
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Mode selects how much isolation a Sandbox provides.
+type Mode string
+
+const (
+	// None runs blocks directly in the caller's shell, with full host access.
+	None Mode = "none"
+	// TmpDir runs blocks in a fresh scratch directory with a scrubbed environment.
+	TmpDir Mode = "tmpdir"
+	// Docker runs blocks inside a throwaway container.
+	Docker Mode = "docker"
+)
+
+// DefaultImage is the Docker image used when a bucket or block does
+// not specify one.
+const DefaultImage = "bash:5"
+
+// Options configures a Sandbox.
+type Options struct {
+	Mode Mode
+	// Image is the Docker image to use in Docker mode. Defaults to DefaultImage.
+	Image string
+	// Env holds additional "KEY=VALUE" pairs visible to the block.
+	Env []string
+	// Keep leaves the scratch directory behind instead of removing it
+	// on Close, for postmortem debugging (mirrors the "keep" flag in
+	// Go's own test/run.go).
+	Keep bool
+}
+
+// Sandbox prepares an isolated environment for a bucket's worth of
+// blocks and knows how to build the *exec.Cmd that runs a given
+// script inside it. Each call to Command gets its own scratch
+// directory nested under dir, so blocks dispatched concurrently (as
+// --parallel does, one goroutine per block rather than per bucket)
+// never share a working directory or Docker mount.
+type Sandbox struct {
+	opts Options
+	dir  string
+}
+
+// New creates a Sandbox for opts, allocating a scratch directory for
+// TmpDir and Docker modes.
+func New(opts Options) (*Sandbox, error) {
+	s := &Sandbox{opts: opts}
+	if opts.Mode == TmpDir || opts.Mode == Docker {
+		dir, err := ioutil.TempDir("", "mdrip-")
+		if err != nil {
+			return nil, fmt.Errorf("sandbox: %v", err)
+		}
+		s.dir = dir
+	}
+	return s, nil
+}
+
+// Close releases the scratch directory, unless Options.Keep was set.
+func (s *Sandbox) Close() error {
+	if s.dir == "" || s.opts.Keep {
+		return nil
+	}
+	return os.RemoveAll(s.dir)
+}
+
+// Command builds the command that runs script under this sandbox.
+// image and env, when non-empty, override the sandbox's own Options
+// for this one block (from a per-block directive comment). In TmpDir
+// and Docker modes, each call gets its own fresh scratch directory
+// under s.dir, so concurrent blocks never clobber each other's files.
+func (s *Sandbox) Command(ctx context.Context, script, image string, env []string) (*exec.Cmd, error) {
+	switch s.opts.Mode {
+	case TmpDir:
+		dir, err := s.blockDir()
+		if err != nil {
+			return nil, err
+		}
+		cmd := exec.CommandContext(ctx, "bash", "-e")
+		cmd.Stdin = strings.NewReader(script)
+		cmd.Dir = dir
+		cmd.Env = s.scrubbedEnv(dir, env)
+		killOnCancel(cmd)
+		return cmd, nil
+	case Docker:
+		dir, err := s.blockDir()
+		if err != nil {
+			return nil, err
+		}
+		if image == "" {
+			image = s.opts.Image
+		}
+		if image == "" {
+			image = DefaultImage
+		}
+		args := []string{"run", "-i", "--rm", "-v", dir + ":/work", "-w", "/work"}
+		for _, e := range append(append([]string{}, s.opts.Env...), env...) {
+			args = append(args, "-e", e)
+		}
+		args = append(args, image, "bash", "-e")
+		cmd := exec.CommandContext(ctx, "docker", args...)
+		cmd.Stdin = strings.NewReader(script)
+		killOnCancel(cmd)
+		return cmd, nil
+	default: // None
+		cmd := exec.CommandContext(ctx, "bash", "-e")
+		cmd.Stdin = strings.NewReader(script)
+		if len(s.opts.Env) > 0 || len(env) > 0 {
+			cmd.Env = append(append(os.Environ(), s.opts.Env...), env...)
+		}
+		killOnCancel(cmd)
+		return cmd, nil
+	}
+}
+
+// killOnCancel puts cmd in its own process group and arranges for
+// ctx's cancellation (e.g. a block's timeout directive elapsing) to
+// kill that whole group, not just cmd itself. Without this, a script
+// like "sleep 5" runs as bash's child: killing bash alone leaves
+// sleep orphaned and still holding the stdout pipe open, so Cmd.Wait
+// blocks until sleep exits on its own regardless of the timeout.
+func killOnCancel(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 2 * time.Second
+}
+
+// blockDir allocates a fresh scratch directory for one block's
+// Command, nested under s.dir so Close still removes it.
+func (s *Sandbox) blockDir() (string, error) {
+	dir, err := ioutil.TempDir(s.dir, "block-")
+	if err != nil {
+		return "", fmt.Errorf("sandbox: %v", err)
+	}
+	return dir, nil
+}
+
+// scrubbedEnv returns a minimal PATH/HOME pointed at blockDir plus
+// the sandbox's own Env and any per-block overrides, so a tmpdir
+// block can't see the caller's environment by accident.
+func (s *Sandbox) scrubbedEnv(blockDir string, blockEnv []string) []string {
+	env := []string{
+		"PATH=/usr/bin:/bin",
+		"HOME=" + blockDir,
+	}
+	env = append(env, s.opts.Env...)
+	env = append(env, blockEnv...)
+	return env
+}
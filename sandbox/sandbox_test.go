@@ -0,0 +1,93 @@
+package sandbox
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestNoneCommand(t *testing.T) {
+	s, err := New(Options{Mode: None})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	cmd, err := s.Command(context.Background(), "echo hi", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd.Path == "" || !strings.HasSuffix(cmd.Path, "bash") {
+		t.Errorf("got path %q, want a bash binary", cmd.Path)
+	}
+}
+
+func TestTmpDirCleansUpUnlessKept(t *testing.T) {
+	s, err := New(Options{Mode: TmpDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := s.dir
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected %q to be removed, stat err = %v", dir, err)
+	}
+
+	s, err = New(Options{Mode: TmpDir, Keep: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir = s.dir
+	defer os.RemoveAll(dir)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("expected %q to survive Close with Keep, stat err = %v", dir, err)
+	}
+}
+
+func TestTmpDirCommandGetsFreshDirPerCall(t *testing.T) {
+	s, err := New(Options{Mode: TmpDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	cmd1, err := s.Command(context.Background(), "echo one", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd2, err := s.Command(context.Background(), "echo two", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd1.Dir == cmd2.Dir {
+		t.Errorf("two Command calls shared dir %q; concurrent blocks would clobber each other's files", cmd1.Dir)
+	}
+	if !strings.HasPrefix(cmd1.Dir, s.dir) || !strings.HasPrefix(cmd2.Dir, s.dir) {
+		t.Errorf("got dirs %q, %q, want both nested under sandbox dir %q", cmd1.Dir, cmd2.Dir, s.dir)
+	}
+}
+
+func TestDockerCommandUsesDefaultImage(t *testing.T) {
+	s, err := New(Options{Mode: Docker})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	cmd, err := s.Command(context.Background(), "echo hi", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, a := range cmd.Args {
+		if a == DefaultImage {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("args %v do not include default image %q", cmd.Args, DefaultImage)
+	}
+}
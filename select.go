@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jxson/mdrip/pipeline"
+)
+
+// Select resolves a label expression against m, the label -> blocks
+// map produced by Parse, and returns the matching blocks.
+//
+// An expression is a single label (foo), or a list of labels joined
+// by exactly one of:
+//
+//	foo,bar   union: any block labeled foo OR bar
+//	foo+bar   intersection: only blocks labeled both foo AND bar
+//	foo-bar   difference: blocks labeled foo but not bar
+//
+// Mixing operators (foo,bar+baz) is rejected; compose selectors
+// outside mdrip if you need that. Within a result set, blocks are
+// deduplicated by seq (so distinct blocks that happen to share a
+// label set and code text are kept separate), and ordered by
+// document position, regardless of term order.
+//
+// The expression grammar itself is pipeline.SplitExpr's, so this and
+// pipeline.SelectLabel can't drift apart on what counts as valid.
+func Select(expr string, m map[string][]block) ([]block, error) {
+	terms, op, err := pipeline.SplitExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	if op == 0 {
+		blocks, ok := m[expr]
+		if !ok {
+			return nil, fmt.Errorf("mdrip: no block labelled %q", expr)
+		}
+		return blocks, nil
+	}
+	var result []block
+	switch op {
+	case ',':
+		result = selectUnion(terms, m)
+	case '+':
+		result = selectIntersection(terms, m)
+	default:
+		result = selectDifference(terms, m)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].seq < result[j].seq })
+	return result, nil
+}
+
+// blockKey identifies a block for dedup purposes across the per-label
+// slices in m, which each hold independently-built copies of any
+// block carrying more than one label. seq already uniquely identifies
+// a block across its per-label copies, so it's what distinguishes
+// them, not their (possibly duplicate) label set and code text.
+func blockKey(b block) int {
+	return b.seq
+}
+
+func selectUnion(terms []string, m map[string][]block) []block {
+	seen := make(map[int]bool)
+	var result []block
+	for _, t := range terms {
+		for _, b := range m[t] {
+			k := blockKey(b)
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+func selectIntersection(terms []string, m map[string][]block) []block {
+	if len(terms) == 0 {
+		return nil
+	}
+	rest := make([]map[int]bool, len(terms)-1)
+	for i, t := range terms[1:] {
+		rest[i] = make(map[int]bool)
+		for _, b := range m[t] {
+			rest[i][blockKey(b)] = true
+		}
+	}
+	seen := make(map[int]bool)
+	var result []block
+	for _, b := range m[terms[0]] {
+		k := blockKey(b)
+		if seen[k] {
+			continue
+		}
+		inAll := true
+		for _, s := range rest {
+			if !s[k] {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			seen[k] = true
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+func selectDifference(terms []string, m map[string][]block) []block {
+	if len(terms) == 0 {
+		return nil
+	}
+	exclude := make(map[int]bool)
+	for _, t := range terms[1:] {
+		for _, b := range m[t] {
+			exclude[blockKey(b)] = true
+		}
+	}
+	seen := make(map[int]bool)
+	var result []block
+	for _, b := range m[terms[0]] {
+		k := blockKey(b)
+		if seen[k] || exclude[k] {
+			continue
+		}
+		seen[k] = true
+		result = append(result, b)
+	}
+	return result
+}
@@ -0,0 +1,96 @@
+package main
+
+import "testing"
+
+func labels(blocks []block) (out []string) {
+	for _, b := range blocks {
+		out = append(out, b.labels[0])
+	}
+	return out
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSelect(t *testing.T) {
+	m := map[string][]block{
+		"foo": {{labels: []string{"foo"}, codeText: "echo foo", seq: 0}},
+		"bar": {{labels: []string{"bar"}, codeText: "echo bar", seq: 1}},
+		"baz": {{labels: []string{"baz"}, codeText: "echo baz", seq: 2}},
+	}
+	tests := []struct {
+		name    string
+		expr    string
+		want    []string
+		wantErr bool
+	}{
+		{"single", "foo", []string{"foo"}, false},
+		{"union", "foo,bar", []string{"foo", "bar"}, false},
+		{"intersection none", "foo+bar", nil, false},
+		{"difference", "foo-bar", []string{"foo"}, false},
+		{"unknown label", "nope", nil, true},
+		{"mixed operators", "foo,bar+baz", nil, true},
+	}
+	for _, test := range tests {
+		got, err := Select(test.expr, m)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got err %v, wantErr %v", test.name, err, test.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !sameStrings(labels(got), test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, labels(got), test.want)
+		}
+	}
+}
+
+func TestSelectPreservesDocumentOrder(t *testing.T) {
+	// "bar" appears before "foo" in the document (lower seq), but the
+	// expression names "foo" first: the result must still come back in
+	// document order, not term order.
+	m := map[string][]block{
+		"foo": {{labels: []string{"foo"}, codeText: "echo foo", seq: 1}},
+		"bar": {{labels: []string{"bar"}, codeText: "echo bar", seq: 0}},
+	}
+	got, err := Select("foo,bar", m)
+	if err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+	if want := []string{"bar", "foo"}; !sameStrings(labels(got), want) {
+		t.Errorf("got %v, want %v", labels(got), want)
+	}
+}
+
+func TestSelectKeepsDistinctBlocksWithIdenticalContent(t *testing.T) {
+	// Two separate blocks in the document share both a label set and
+	// code text (e.g. two scenarios that both start with "echo hi"),
+	// but have distinct seq: they must not be collapsed into one.
+	m := map[string][]block{
+		"foo": {
+			{labels: []string{"foo", "bar"}, codeText: "echo hi", seq: 0},
+			{labels: []string{"foo", "bar"}, codeText: "echo hi", seq: 2},
+		},
+		"bar": {
+			{labels: []string{"foo", "bar"}, codeText: "echo hi", seq: 0},
+			{labels: []string{"foo", "bar"}, codeText: "echo hi", seq: 2},
+		},
+	}
+	got, err := Select("foo,bar", m)
+	if err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+	if len(got) != 2 {
+		t.Errorf("got %d blocks, want 2 distinct blocks", len(got))
+	}
+}
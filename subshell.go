@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// ScriptBucket groups the blocks extracted from one file for one
+// label expression, in document order.
+type ScriptBucket struct {
+	fileName string
+	script   []block
+}
+
+// subShellResult is what RunInSubShell reports about a run.
+type subShellResult struct {
+	err    error
+	output string
+}
+
+// RunInSubShell concatenates every block in every bucket into one
+// script and runs it in a single "bash -e" subshell, returning once
+// the whole thing exits.
+func RunInSubShell(scriptBuckets []*ScriptBucket) subShellResult {
+	var script bytes.Buffer
+	for _, bucket := range scriptBuckets {
+		for _, b := range bucket.script {
+			fmt.Fprint(&script, b.codeText)
+		}
+	}
+	cmd := exec.Command("bash", "-e")
+	cmd.Stdin = &script
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	return subShellResult{err: err, output: out.String()}
+}
+
+// Complain reports a failed subshell run to stderr.
+func Complain(result subShellResult, label string) {
+	fmt.Fprintf(os.Stderr, "Error running label %q: %v\n%s\n", label, result.err, result.output)
+}